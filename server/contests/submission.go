@@ -2,11 +2,13 @@ package contests
 
 import (
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"git.nkagami.me/natsukagami/kjudge/db"
 	"git.nkagami.me/natsukagami/kjudge/models"
+	"git.nkagami.me/natsukagami/kjudge/worker"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 )
@@ -19,10 +21,19 @@ type SubmissionCtx struct {
 	Problem     *models.Problem
 	TestGroups  []*models.TestGroupWithTests
 	TestResults map[int]*models.TestResult
+	// SkippedGroups maps a test group's ID to the dependency group ID that
+	// blocked it (see worker.UnsatisfiedDependency), for groups scoring 0
+	// points purely because an IOI-style subtask prerequisite didn't score
+	// full marks. The submission page badges these as "skipped: depends on
+	// subtask N" rather than showing a plain zero.
+	SkippedGroups map[int]int
 }
 
-// Collect a submission ctx.
+// Collect a submission ctx. Every DB call is bound to the request's
+// context, so a client disconnecting (or a handler timeout) stops the
+// lookup instead of leaking it to completion.
 func getSubmissionCtx(db db.DBContext, c echo.Context) (*SubmissionCtx, error) {
+	ctx := c.Request().Context()
 	contest, err := getContestCtx(db, c)
 	if err != nil {
 		return nil, err
@@ -33,7 +44,7 @@ func getSubmissionCtx(db db.DBContext, c echo.Context) (*SubmissionCtx, error) {
 		return nil, echo.ErrNotFound
 	}
 
-	sub, err := models.GetSubmission(db, id)
+	sub, err := models.GetSubmission(ctx, db, id)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, echo.ErrNotFound
 	} else if err != nil {
@@ -45,7 +56,7 @@ func getSubmissionCtx(db db.DBContext, c echo.Context) (*SubmissionCtx, error) {
 		return nil, echo.ErrForbidden
 	}
 
-	problem, err := models.GetProblem(db, sub.ProblemID)
+	problem, err := models.GetProblem(ctx, db, sub.ProblemID)
 	if err != nil {
 		return nil, err
 	}
@@ -54,14 +65,15 @@ func getSubmissionCtx(db db.DBContext, c echo.Context) (*SubmissionCtx, error) {
 		return nil, echo.ErrNotFound
 	}
 
-	testGroups, err := models.GetProblemTestsMeta(db, problem.ID)
+	testGroups, err := models.GetProblemTestsMeta(ctx, db, problem.ID)
 	if err != nil {
 		return nil, err
 	}
 
 	var testResults map[int]*models.TestResult
+	var skippedGroups map[int]int
 	if sub.Score.Valid {
-		trs, err := models.GetSubmissionTestResults(db, sub.ID)
+		trs, err := models.GetSubmissionTestResults(ctx, db, sub.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -69,17 +81,49 @@ func getSubmissionCtx(db db.DBContext, c echo.Context) (*SubmissionCtx, error) {
 		for _, tr := range trs {
 			testResults[tr.TestID] = tr
 		}
+		skippedGroups = skippedTestGroups(testGroups, testResults)
 	}
 
 	return &SubmissionCtx{
-		ContestCtx:  contest,
-		Submission:  sub,
-		Problem:     problem,
-		TestGroups:  testGroups,
-		TestResults: testResults,
+		ContestCtx:    contest,
+		Submission:    sub,
+		Problem:       problem,
+		TestGroups:    testGroups,
+		TestResults:   testResults,
+		SkippedGroups: skippedGroups,
 	}, nil
 }
 
+// skippedTestGroups mirrors worker.Score's dependency gating read-only, to
+// tell the submission page which groups scored 0 because a subtask
+// prerequisite wasn't satisfied rather than because the tests themselves
+// failed.
+func skippedTestGroups(testGroups []*models.TestGroupWithTests, testResults map[int]*models.TestResult) map[int]int {
+	groupsByID := make(map[int]*models.TestGroupWithTests, len(testGroups))
+	for _, tg := range testGroups {
+		groupsByID[tg.ID] = tg
+	}
+	ordered, err := worker.TopologicalOrder(testGroups)
+	if err != nil {
+		return nil
+	}
+
+	skipped := make(map[int]int)
+	achieved := make(map[int]float64, len(ordered))
+	for _, tg := range ordered {
+		score, counts := worker.ScoreGroup(tg, testResults)
+		if !counts {
+			continue
+		}
+		if dep := worker.UnsatisfiedDependency(tg, groupsByID, achieved); dep != 0 {
+			skipped[tg.ID] = dep
+			score = 0
+		}
+		achieved[tg.ID] = score
+	}
+	return skipped
+}
+
 // Render renders the context.
 func (ctx *SubmissionCtx) Render(c echo.Context) error {
 	return c.Render(http.StatusOK, "contests/submission", ctx)
@@ -91,4 +135,78 @@ func (g *Group) SubmissionGet(c echo.Context) error {
 		return err
 	}
 	return ctx.Render(c)
+}
+
+// SubmissionCancelRejudge cancels any scoring currently in flight for this
+// submission, e.g. because it was just queued for another rejudge and the
+// admin doesn't want to wait for the stale one to finish writing its
+// result first. It reuses getSubmissionCtx for auth.
+func (g *Group) SubmissionCancelRejudge(c echo.Context) error {
+	ctx, err := getSubmissionCtx(g.db, c)
+	if err != nil {
+		return err
+	}
+	worker.Cancel(worker.RejudgeBatch(ctx.Submission.ID))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SubmissionStream streams a submission's test results as they are produced
+// by the worker, as server-sent events, so the submission page no longer
+// needs to poll. It reuses getSubmissionCtx for auth: only the submission's
+// owner may subscribe; getSubmissionCtx makes no exception for admins.
+func (g *Group) SubmissionStream(c echo.Context) error {
+	ctx, err := getSubmissionCtx(g.db, c)
+	if err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	// The submission was already scored before the client connected (e.g. a
+	// page refresh after scoring finished): worker.PublishScored fired long
+	// ago and nothing will ever arrive on a fresh subscription. Write the
+	// terminal event straight away instead of subscribing and leaking the
+	// channel until the client gives up.
+	if ctx.Submission.Score.Valid {
+		event := worker.TestResultEvent{SubmissionID: ctx.Submission.ID, Done: true, Submission: ctx.Submission}
+		return writeSubmissionEvent(res, event)
+	}
+
+	events, unsubscribe := worker.SubscribeResults(ctx.Submission.ID)
+	defer unsubscribe()
+
+	req := c.Request().Context()
+	for {
+		select {
+		case <-req.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				// We were dropped for being too slow (see worker.resultBus).
+				// Ending the stream makes the client's EventSource retry.
+				return nil
+			}
+			if err := writeSubmissionEvent(res, event); err != nil {
+				return err
+			}
+			res.Flush()
+			if event.Done {
+				return nil
+			}
+		}
+	}
+}
+
+// writeSubmissionEvent writes event as a single SSE "message" frame.
+func writeSubmissionEvent(res *echo.Response, event worker.TestResultEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = res.Write([]byte("data: " + string(payload) + "\n\n"))
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,74 @@
+// Package workers implements the HTTP endpoints judge workers use to join
+// the pull-based scheduler introduced in worker.Dispatcher: a worker
+// registers its capability labels once at startup, then polls in a loop
+// for the next job it is eligible to run.
+package workers
+
+import (
+	"net/http"
+
+	"git.nkagami.me/natsukagami/kjudge/db"
+	"git.nkagami.me/natsukagami/kjudge/worker"
+	"github.com/labstack/echo/v4"
+)
+
+// Group holds the worker registry and dispatcher shared across requests.
+type Group struct {
+	db         db.DBContext
+	registry   *worker.Registry
+	dispatcher *worker.Dispatcher
+}
+
+// NewGroup creates a Group backed by conn, with a fresh, empty worker
+// registry.
+func NewGroup(conn db.DBContext) *Group {
+	registry := worker.NewRegistry()
+	return &Group{
+		db:         conn,
+		registry:   registry,
+		dispatcher: worker.NewDispatcher(registry),
+	}
+}
+
+// registerRequest is the body of POST /workers/register.
+type registerRequest struct {
+	ID     string `json:"id"`
+	Labels string `json:"labels"` // e.g. "sandbox=isolate,arch=x86_64,gpu=true"
+}
+
+// Register lets a worker process announce itself and its capability labels
+// before it starts polling for jobs.
+func (g *Group) Register(c echo.Context) error {
+	var req registerRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.ErrBadRequest
+	}
+	if req.ID == "" {
+		return echo.ErrBadRequest
+	}
+	g.registry.Register(req.ID, worker.ParseLabels(req.Labels))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Poll is called by a registered worker in a loop: it claims and returns
+// the next job the worker is eligible for, or 204 if there is nothing for
+// it right now.
+func (g *Group) Poll(c echo.Context) error {
+	job, err := g.dispatcher.Next(c.Request().Context(), g.db, c.Param("id"))
+	if err == worker.ErrWorkerNotRegistered {
+		return echo.ErrForbidden
+	} else if err != nil {
+		return err
+	}
+	if job == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// Done is called once a worker finishes (or gives up on) a job claimed via
+// Poll, releasing the load slot Next reserved for it.
+func (g *Group) Done(c echo.Context) error {
+	g.dispatcher.Release(c.Param("id"))
+	return c.NoContent(http.StatusNoContent)
+}
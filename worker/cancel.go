@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// cancellations tracks the cancel funcs for in-flight rejudge batches and
+// per-contest judging, keyed by an opaque batch ID chosen by the caller
+// (e.g. a rejudge request ID, or a contest ID for "cancel everything in
+// this contest"). It lets an admin HTTP handler stop scoring/judging that
+// was kicked off by a previous request without having to reach into the
+// worker goroutines directly.
+//
+// A batch can have more than one registration at once (e.g. a rejudge
+// batch scoring several submissions concurrently, all sharing the same
+// batch ID), so registrations are keyed by a private per-call token rather
+// than the bare batch string: releasing one registration must not disturb
+// its siblings, and Cancel(batch) must stop all of them.
+var cancellations = struct {
+	sync.Mutex
+	nextToken int
+	batches   map[string]map[int]context.CancelFunc
+}{batches: make(map[string]map[int]context.CancelFunc)}
+
+// WithCancel derives a cancellable context for batch, registering it so a
+// later call to Cancel(batch) stops it (and any other registration sharing
+// the same batch ID). The returned cancel func must still be called once
+// this registration is done, to release it; doing so only removes this
+// registration, not the whole batch.
+func WithCancel(parent context.Context, batch string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	cancellations.Lock()
+	if cancellations.batches[batch] == nil {
+		cancellations.batches[batch] = make(map[int]context.CancelFunc)
+	}
+	cancellations.nextToken++
+	token := cancellations.nextToken
+	cancellations.batches[batch][token] = cancel
+	cancellations.Unlock()
+
+	return ctx, func() {
+		cancellations.Lock()
+		if regs, ok := cancellations.batches[batch]; ok {
+			delete(regs, token)
+			if len(regs) == 0 {
+				delete(cancellations.batches, batch)
+			}
+		}
+		cancellations.Unlock()
+		cancel()
+	}
+}
+
+// Cancel stops every in-flight registration under batch (see WithCancel).
+// It is a no-op if the batch is unknown, e.g. because it already finished.
+func Cancel(batch string) {
+	cancellations.Lock()
+	regs := cancellations.batches[batch]
+	cancels := make([]context.CancelFunc, 0, len(regs))
+	for _, cancel := range regs {
+		cancels = append(cancels, cancel)
+	}
+	cancellations.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"git.nkagami.me/natsukagami/kjudge/models"
+	"github.com/pkg/errors"
+)
+
+// Testlib's de-facto exit code contract. A checker/interactor binary
+// communicates its verdict purely through its exit status (plus, for
+// partial credit, a "points N" line on stderr).
+const (
+	testlibExitAccepted     = 0
+	testlibExitWrong        = 1
+	testlibExitPresentation = 2
+	testlibExitFail         = 3
+	testlibExitPartial      = 7
+)
+
+// CheckerVerdict is the normalised result of running a checker, regardless
+// of which CheckerType produced it.
+type CheckerVerdict struct {
+	Score   float64 // In [0, 1].
+	Verdict string  // One of the Checker* constants below.
+}
+
+// Checker verdict strings, distinct from the submission-level Verdict*
+// constants in score.go: these describe a single test, not a submission.
+const (
+	CheckerAccepted     = "Accepted"
+	CheckerWrongAnswer  = "Wrong Answer"
+	CheckerPresentation = "Presentation Error"
+	CheckerFail         = "Checker Failed"
+)
+
+// pointsPrefix is the line testlib's `quitf(_pc, ...)` / manual `points N`
+// convention writes to stderr to report a partial score for exit code 7.
+const pointsPrefix = "points "
+
+// verdictForExitCode maps a testlib-style exit code (plus the stderr
+// produced alongside it, for the partial-credit case) to a CheckerVerdict.
+// Shared by RunChecker and RunInteractor so the two codepaths can't drift
+// out of sync on what a given exit code means.
+func verdictForExitCode(exitCode int, stderr string) (*CheckerVerdict, error) {
+	switch exitCode {
+	case testlibExitAccepted:
+		return &CheckerVerdict{Score: 1.0, Verdict: CheckerAccepted}, nil
+	case testlibExitWrong:
+		return &CheckerVerdict{Score: 0.0, Verdict: CheckerWrongAnswer}, nil
+	case testlibExitPresentation:
+		return &CheckerVerdict{Score: 0.0, Verdict: CheckerPresentation}, nil
+	case testlibExitPartial:
+		points, err := parsePoints(stderr)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing partial score")
+		}
+		return &CheckerVerdict{Score: points, Verdict: CheckerAccepted}, nil
+	default:
+		return &CheckerVerdict{Score: 0.0, Verdict: CheckerFail}, nil
+	}
+}
+
+// runCmdWithContext starts cmd and waits for it to exit, killing its
+// process the moment ctx is cancelled instead of waiting it out — this is
+// what lets worker.Cancel actually stop a running checker/interactor
+// sandbox process, not just stop Score's loop from writing further DB
+// state at its next ctx.Err() check.
+func runCmdWithContext(ctx context.Context, cmd *exec.Cmd) (exitCode int, err error) {
+	if err := cmd.Start(); err != nil {
+		return 0, errors.Wrap(err, "starting process")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		<-done // Reap the process; its exit status doesn't matter anymore.
+		return 0, ctx.Err()
+	case waitErr := <-done:
+		if exitErr, ok := waitErr.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		if waitErr != nil {
+			return 0, errors.Wrap(waitErr, "running process")
+		}
+		return 0, nil
+	}
+}
+
+// RunChecker runs a testlib-style checker binary (input, contestant output,
+// expected answer as its three positional args, same as testlib's
+// `checker input output answer`) and normalises its exit code and stderr
+// into a CheckerVerdict. This is the `testlib` CheckerType; `default` and
+// `token` checkers are expected to already produce a TestResult.Score
+// directly and never go through here.
+func RunChecker(ctx context.Context, cmd *exec.Cmd) (*CheckerVerdict, error) {
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	exitCode, err := runCmdWithContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return verdictForExitCode(exitCode, stderr.String())
+}
+
+// parsePoints extracts N from a "points N" line in the checker's stderr, as
+// written by testlib's partial-score helpers, and rejects anything outside
+// [0, 1]: CheckerVerdict.Score is a ratio of the test's weight, not a
+// percentage, and a checker emitting e.g. "points 50" must not silently
+// multiply straight into ScoreGroup's tg.Score * ratio.
+func parsePoints(stderr string) (float64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(stderr))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, pointsPrefix) {
+			continue
+		}
+		points, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(line, pointsPrefix)), 64)
+		if err != nil {
+			return 0, err
+		}
+		if points < 0 || points > 1 {
+			return 0, errors.Errorf(`"points %v" out of range [0, 1]`, points)
+		}
+		return points, nil
+	}
+	return 0, errors.New(`exit code 7 but no "points N" line on stderr`)
+}
+
+// RunInteractor spawns an interactor binary and wires its stdin/stdout to
+// the contestant's process, judge-side: the interactor reads the
+// contestant's output on its stdin and writes prompts to its stdout. The
+// test's input data (judgeInput) is staged to a temp file and passed as the
+// interactor's first argument, following testlib's own interactor
+// convention (`interactor input-file ...`), since that's how an interactor
+// knows what test it's judging. Time and memory accounting for the run
+// must be attributed to the contestant process (the caller's sandbox), not
+// to the interactor, since the interactor is judge infrastructure.
+func RunInteractor(ctx context.Context, interactorPath string, contestantStdin io.Writer, contestantStdout io.Reader, judgeInput io.Reader) (*CheckerVerdict, error) {
+	inputFile, err := os.CreateTemp("", "kjudge-interactor-input-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "staging interactor input")
+	}
+	defer os.Remove(inputFile.Name())
+	defer inputFile.Close()
+	if _, err := io.Copy(inputFile, judgeInput); err != nil {
+		return nil, errors.Wrap(err, "staging interactor input")
+	}
+
+	cmd := exec.Command(interactorPath, inputFile.Name())
+	cmd.Stdin = contestantStdout
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "wiring interactor stdout")
+	}
+	go func() {
+		_, _ = io.Copy(contestantStdin, stdout)
+	}()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	exitCode, err := runCmdWithContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return verdictForExitCode(exitCode, stderr.String())
+}
+
+// CheckerInputs bundles everything RunCheckerFor might need to hand off to
+// either RunChecker or RunInteractor, since which of the two applies
+// depends on the problem's CheckerType/InteractorPath and isn't known until
+// dispatch time.
+type CheckerInputs struct {
+	// CheckerCmd is a fully-wired `checker input output answer` command,
+	// used for CheckerTypeTestlib/CheckerTypeCustom problems that don't set
+	// InteractorPath.
+	CheckerCmd *exec.Cmd
+	// JudgeInput/ContestantStdin/ContestantStdout are used instead, for
+	// problems that do set InteractorPath.
+	JudgeInput       io.Reader
+	ContestantStdin  io.Writer
+	ContestantStdout io.Reader
+}
+
+// RunCheckerFor is the job-run path's single dispatch point between the
+// testlib exit-code checker and the interactor mode: it reads problem's
+// CheckerType/InteractorPath and calls whichever of RunChecker/RunInteractor
+// applies, so ScoreGroup always receives a properly-normalised partial
+// score regardless of which kind of problem it's scoring.
+//
+// CheckerTypeDefault and CheckerTypeToken are not handled here: for those
+// (and any other checker type) RunCheckerFor returns an error without
+// running anything.
+func RunCheckerFor(ctx context.Context, problem *models.Problem, in CheckerInputs) (*CheckerVerdict, error) {
+	switch problem.CheckerType {
+	case models.CheckerTypeTestlib, models.CheckerTypeCustom:
+		if problem.InteractorPath != "" {
+			return RunInteractor(ctx, problem.InteractorPath, in.ContestantStdin, in.ContestantStdout, in.JudgeInput)
+		}
+		return RunChecker(ctx, in.CheckerCmd)
+	default:
+		return nil, errors.Errorf("worker: checker type %q does not use RunCheckerFor", problem.CheckerType)
+	}
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+
+	"git.nkagami.me/natsukagami/kjudge/db"
+	"git.nkagami.me/natsukagami/kjudge/models"
+	"github.com/pkg/errors"
+)
+
+// ErrWorkerNotRegistered is returned by Dispatcher.Next when asked for a job
+// on behalf of a worker ID that never called Registry.Register (or was
+// since Unregistered), e.g. after a restart.
+var ErrWorkerNotRegistered = errors.New("worker: not registered")
+
+// Dispatcher turns the single-process job loop into a pull-based
+// scheduler: instead of every worker racing to grab rows off the same
+// queue, each registered worker repeatedly calls Next, and the dispatcher
+// claims the best job it is eligible for according to PickWorker.
+type Dispatcher struct {
+	Registry *Registry
+}
+
+// NewDispatcher creates a Dispatcher backed by the given worker registry.
+func NewDispatcher(r *Registry) *Dispatcher {
+	return &Dispatcher{Registry: r}
+}
+
+// Next claims and returns the next job workerID should run, or nil if
+// nothing pending is eligible for it right now. Jobs are considered in
+// queue order; a job's required labels come from its problem's
+// constraints (models.Problem.RequiredLabels), and the first pending job
+// for which workerID is PickWorker's choice among every currently
+// registered worker (see Registry.Candidates) is claimed. If a better
+// candidate than workerID is registered, Next skips the job and leaves it
+// pending for that worker's own poll to pick up instead.
+func (d *Dispatcher) Next(ctx context.Context, conn db.DBContext, workerID string) (*models.Job, error) {
+	if _, ok := d.Registry.Get(workerID); !ok {
+		return nil, ErrWorkerNotRegistered
+	}
+
+	jobs, err := models.GetPendingJobs(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching pending jobs")
+	}
+	for _, job := range jobs {
+		problem, err := models.GetProblemForJob(ctx, conn, job)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolving job's problem")
+		}
+		required := RequiredLabels(problem)
+		best := PickWorker(d.Registry.Candidates(), required)
+		if best == nil || best.ID != workerID {
+			continue
+		}
+		if err := models.ClaimJob(ctx, conn, job.ID, workerID); err != nil {
+			return nil, errors.Wrap(err, "claiming job")
+		}
+		d.Registry.IncrLoad(workerID, 1)
+		return job, nil
+	}
+	return nil, nil
+}
+
+// Release frees up the load slot Next reserved for a claimed job, once the
+// worker reports the job done (or abandons it). Callers must call this
+// exactly once per successful Next.
+func (d *Dispatcher) Release(workerID string) {
+	d.Registry.IncrLoad(workerID, -1)
+}
@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"strings"
+	"sync"
+
+	"git.nkagami.me/natsukagami/kjudge/models"
+)
+
+// labelWildcard is the special label value that matches anything required
+// by a job, e.g. a worker declaring `lang=*` can run a job requiring
+// `lang=cpp`.
+const labelWildcard = "*"
+
+// exactMatchScore and wildcardMatchScore are the points awarded to a worker
+// for satisfying a single required label, used to break ties between
+// workers that are otherwise all eligible to run a job.
+const (
+	exactMatchScore    = 10
+	wildcardMatchScore = 1
+)
+
+// Labels is a set of `key=value` capability declarations, either advertised
+// by a worker at startup or required by a problem/submission.
+//
+// A label with an empty value (e.g. `gpu`) is shorthand for `gpu=true`.
+type Labels map[string]string
+
+// ParseLabels parses a comma-separated list of `key=value` pairs, as passed
+// on the worker's command line (e.g. `sandbox=isolate,arch=x86_64,gpu=true`).
+func ParseLabels(raw string) Labels {
+	labels := make(Labels)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 1 {
+			labels[kv[0]] = "true"
+			continue
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels
+}
+
+// Worker is a registered judging worker, identified by an ID and the
+// capability labels it advertised at registration time.
+type Worker struct {
+	ID     string
+	Labels Labels
+	// Load is the number of jobs the worker is currently running, used to
+	// break scoring ties in favour of the least busy worker.
+	Load int
+}
+
+// satisfies reports whether the worker's labels satisfy every label in
+// required. A worker value of `*` matches any required value for that key.
+func (w *Worker) satisfies(required Labels) bool {
+	for key, want := range required {
+		have, ok := w.Labels[key]
+		if !ok {
+			return false
+		}
+		if have == labelWildcard {
+			continue
+		}
+		if have != want {
+			return false
+		}
+	}
+	return true
+}
+
+// score rates how well the worker's labels match required. Disqualified
+// workers (see satisfies) must not be scored.
+func (w *Worker) score(required Labels) int {
+	total := 0
+	for key, want := range required {
+		have := w.Labels[key]
+		if have == labelWildcard {
+			total += wildcardMatchScore
+		} else if have == want {
+			total += exactMatchScore
+		}
+	}
+	return total
+}
+
+// RequiredLabels extracts the labels a job must be routed to, sourced from
+// the job's problem constraints.
+func RequiredLabels(problem *models.Problem) Labels {
+	return ParseLabels(problem.RequiredLabels)
+}
+
+// PickWorker selects the best worker to run a job out of candidates,
+// following the same filter-then-score approach as Woodpecker's agent
+// matching: workers missing a required label are disqualified, the rest are
+// scored by how closely they match (exact > wildcard), and ties are broken
+// by whichever worker currently has the least load.
+//
+// Returns nil if no worker can run the job.
+func PickWorker(candidates []*Worker, required Labels) *Worker {
+	var best *Worker
+	bestScore := -1
+	for _, w := range candidates {
+		if !w.satisfies(required) {
+			continue
+		}
+		s := w.score(required)
+		switch {
+		case s > bestScore:
+			best, bestScore = w, s
+		case s == bestScore && best != nil && w.Load < best.Load:
+			best = w
+		}
+	}
+	return best
+}
+
+// Registry is the live set of workers that have registered themselves, and
+// is the thing PickWorker is actually run against: a worker process calls
+// Register once at startup (and reports load via IncrLoad as it picks up
+// and finishes jobs), and Dispatcher.Next reads Candidates when deciding
+// who should run the next job off the queue.
+type Registry struct {
+	mu      sync.Mutex
+	workers map[string]*Worker
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{workers: make(map[string]*Worker)}
+}
+
+// Register adds or replaces the worker known as id, with the given labels.
+// Re-registering under an existing id (e.g. after a restart) resets its
+// load to zero.
+func (r *Registry) Register(id string, labels Labels) *Worker {
+	w := &Worker{ID: id, Labels: labels}
+	r.mu.Lock()
+	r.workers[id] = w
+	r.mu.Unlock()
+	return w
+}
+
+// Unregister removes a worker, e.g. once it cleanly shuts down.
+func (r *Registry) Unregister(id string) {
+	r.mu.Lock()
+	delete(r.workers, id)
+	r.mu.Unlock()
+}
+
+// Get looks up a registered worker by id.
+func (r *Registry) Get(id string) (*Worker, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.workers[id]
+	return w, ok
+}
+
+// IncrLoad adjusts a registered worker's current load by delta. It is a
+// no-op if the worker isn't (or is no longer) registered.
+func (r *Registry) IncrLoad(id string, delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.workers[id]; ok {
+		w.Load += delta
+	}
+}
+
+// Candidates returns a snapshot of every registered worker, suitable for
+// passing to PickWorker.
+func (r *Registry) Candidates() []*Worker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]*Worker, 0, len(r.workers))
+	for _, w := range r.workers {
+		out = append(out, w)
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"sync"
+
+	"git.nkagami.me/natsukagami/kjudge/models"
+)
+
+// subscriberBuffer is how many pending TestResultEvents a subscriber may
+// queue before it is considered slow and dropped. This keeps one stalled
+// HTTP client from blocking the worker that is persisting test results.
+const subscriberBuffer = 16
+
+// TestResultEvent is published whenever a test result is persisted for a
+// submission, and once more (with Done set) when the submission's final
+// score is written.
+type TestResultEvent struct {
+	SubmissionID int
+	TestID       int
+	Result       *models.TestResult
+	// Done is set on the final event of a submission's lifecycle, carrying
+	// its finished Verdict/Score instead of a single test's result.
+	Done       bool
+	Submission *models.Submission
+}
+
+// resultBus is a tiny in-process pub/sub for TestResultEvents, scoped per
+// submission ID. It exists so the HTTP layer can push live verdict updates
+// over SSE instead of the submission page polling for them.
+type resultBus struct {
+	mu   sync.Mutex
+	subs map[int]map[chan TestResultEvent]struct{}
+}
+
+var results = &resultBus{subs: make(map[int]map[chan TestResultEvent]struct{})}
+
+// Subscribe registers for events about submissionID. The returned func must
+// be called to unsubscribe and release the channel.
+func (b *resultBus) Subscribe(submissionID int) (<-chan TestResultEvent, func()) {
+	ch := make(chan TestResultEvent, subscriberBuffer)
+	b.mu.Lock()
+	if b.subs[submissionID] == nil {
+		b.subs[submissionID] = make(map[chan TestResultEvent]struct{})
+	}
+	b.subs[submissionID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if subs, ok := b.subs[submissionID]; ok {
+			delete(subs, ch)
+			if len(subs) == 0 {
+				delete(b.subs, submissionID)
+			}
+		}
+	}
+}
+
+// Publish fans event out to every subscriber of its submission. A
+// subscriber that isn't keeping up (its buffer is full) is dropped rather
+// than allowed to block the publisher: it is removed from the bus and its
+// channel is closed, so it receives neither this event nor any later one.
+func (b *resultBus) Publish(event TestResultEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[event.SubmissionID]
+	for ch := range subs {
+		select {
+		case ch <- event:
+		default:
+			delete(subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// PublishTestResult notifies subscribers that a test result was persisted.
+// Call this right after writing the TestResult, e.g. from the Run job.
+func PublishTestResult(submissionID, testID int, result *models.TestResult) {
+	results.Publish(TestResultEvent{SubmissionID: submissionID, TestID: testID, Result: result})
+}
+
+// PublishScored notifies subscribers that a submission finished scoring.
+func PublishScored(sub *models.Submission) {
+	results.Publish(TestResultEvent{SubmissionID: sub.ID, Done: true, Submission: sub})
+}
+
+// SubscribeResults is the entry point used by the HTTP layer to stream a
+// submission's incremental verdicts.
+func SubscribeResults(submissionID int) (<-chan TestResultEvent, func()) {
+	return results.Subscribe(submissionID)
+}
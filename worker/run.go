@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+
+	"git.nkagami.me/natsukagami/kjudge/db"
+	"git.nkagami.me/natsukagami/kjudge/models"
+)
+
+// SaveTestResult persists result and publishes it to anyone streaming the
+// submission's verdicts over SSE (see SubscribeResults). This must be the
+// only place a TestResult is ever written from: writing one directly and
+// forgetting to publish is exactly what used to leave the SSE stream
+// silent until the whole submission finished scoring.
+func SaveTestResult(ctx context.Context, conn db.DBContext, result *models.TestResult) error {
+	if err := result.Write(ctx, conn); err != nil {
+		return err
+	}
+	PublishTestResult(result.SubmissionID, result.TestID, result)
+	return nil
+}
+
+// RunTest executes a single JobRun: run the contestant's program (already
+// done by the caller's sandbox invocation, which supplies in), score it via
+// RunCheckerFor per problem.CheckerType/InteractorPath, then persist and
+// publish the resulting TestResult via SaveTestResult. Only testlib and
+// custom checker problems are handled; for any other CheckerType it returns
+// RunCheckerFor's error and saves nothing.
+func RunTest(ctx context.Context, conn db.DBContext, sub *models.Submission, problem *models.Problem, test *models.Test, in CheckerInputs) (*models.TestResult, error) {
+	verdict, err := RunCheckerFor(ctx, problem, in)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.TestResult{
+		SubmissionID: sub.ID,
+		TestID:       test.ID,
+		Score:        verdict.Score,
+		Verdict:      verdict.Verdict,
+	}
+	if err := SaveTestResult(ctx, conn, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -1,13 +1,16 @@
 package worker
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"math"
+	"strconv"
 	"time"
 
 	"git.nkagami.me/natsukagami/kjudge/models"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -19,20 +22,40 @@ const (
 // ScoreContext is a context for calculating a submission's score
 // and update the user's problem scores.
 type ScoreContext struct {
+	Ctx     context.Context
 	DB      *sqlx.Tx
 	Sub     *models.Submission
 	Problem *models.Problem
 	Contest *models.Contest
 }
 
+// RejudgeBatch returns the cancellation batch ID scoring a submission
+// registers itself under (see worker.Cancel), namely the submission's ID.
+// An admin handler that wants to cancel a submission's in-flight rejudge
+// calls Cancel(RejudgeBatch(id)).
+func RejudgeBatch(submissionID int) string {
+	return strconv.Itoa(submissionID)
+}
+
 // Score does scoring on a submission and updates the user's ProblemResult.
-func Score(s *ScoreContext) error {
+// The scoring is abandoned as soon as ctx is cancelled, e.g. because the
+// submission got rejudged again or the contest was deleted, so a tx rollback
+// in the caller never races an in-flight write. It also registers itself
+// under RejudgeBatch(s.Sub.ID), so an admin action (see worker.Cancel) can
+// cancel this specific submission's in-flight scoring from outside.
+func Score(ctx context.Context, s *ScoreContext) error {
+	ctx, done := WithCancel(ctx, RejudgeBatch(s.Sub.ID))
+	defer done()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// Check if there's any test results missing.
-	testResults, err := s.TestResults()
+	testResults, err := s.TestResults(ctx)
 	if err != nil {
 		return err
 	}
-	tests, err := models.GetProblemTests(s.DB, s.Problem.ID)
+	tests, err := models.GetProblemTests(ctx, s.DB, s.Problem.ID)
 	if err != nil {
 		return err
 	}
@@ -43,39 +66,65 @@ func Score(s *ScoreContext) error {
 			jobs = append(jobs, models.NewJobRun(s.Sub.ID, m.ID))
 		}
 		jobs = append(jobs, models.NewJobScore(s.Sub.ID))
-		return models.BatchInsertJobs(s.DB, jobs...)
+		return models.BatchInsertJobs(ctx, s.DB, jobs...)
 	}
 
 	log.Printf("[WORKER] Scoring submission %d\n", s.Sub.ID)
-	// Calculate the score by summing scores on each test group.
+	// Calculate the score by summing scores on each test group, gating
+	// dependent groups (IOI-style subtasks) on their prerequisites having
+	// scored full marks. Groups must be visited in topological order so a
+	// group scored later in the slice, but depended on by one scored
+	// earlier, is already accounted for. TopologicalOrder also doubles as
+	// our only cycle check today (see DetectDependencyCycle's doc comment):
+	// a cyclic dependency graph surfaces here as an error on this submission,
+	// rather than being rejected earlier at config-load time.
 	s.Sub.Score = sql.NullFloat64{Float64: 0.0, Valid: true}
+	groupsByID := make(map[int]*models.TestGroupWithTests, len(tests))
 	for _, tg := range tests {
+		groupsByID[tg.ID] = tg
+	}
+	ordered, err := TopologicalOrder(tests)
+	if err != nil {
+		return errors.Wrap(err, "invalid test group dependency graph")
+	}
+	achieved := make(map[int]float64, len(tests))
+	for _, tg := range ordered {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		score, counts := ScoreGroup(tg, testResults)
-		if counts {
-			s.Sub.Score.Float64 += score
+		if !counts {
+			continue
+		}
+		if dep := UnsatisfiedDependency(tg, groupsByID, achieved); dep != 0 {
+			log.Printf("[WORKER] Submission %d: test group %d skipped, depends on subtask %d\n", s.Sub.ID, tg.ID, dep)
+			score = 0
 		}
+		achieved[tg.ID] = score
+		s.Sub.Score.Float64 += score
 	}
 	// Calculate penalty too
-	if err := s.ComputePenalties(s.Sub); err != nil {
+	if err := s.ComputePenalties(ctx, s.Sub); err != nil {
 		return err
 	}
 	// Verdict
 	UpdateVerdict(tests, s.Sub)
 	// Write the submission's score
-	if err := s.Sub.Write(s.DB); err != nil {
+	if err := s.Sub.Write(ctx, s.DB); err != nil {
 		return err
 	}
+	PublishScored(s.Sub)
 	log.Printf("[WORKER] Submission %d scored (verdict = %s, score = %.1f). Updating problem results\n", s.Sub.ID, s.Sub.Verdict, s.Sub.Score.Float64)
 
 	// Update the ProblemResult
-	subs, err := models.GetUserProblemSubmissions(s.DB, s.Sub.UserID, s.Problem.ID)
+	subs, err := models.GetUserProblemSubmissions(ctx, s.DB, s.Sub.UserID, s.Problem.ID)
 	if err != nil {
 		return err
 	}
-	pr := s.CompareScores(subs)
+	pr := s.CompareScores(ctx, subs)
 	log.Printf("[WORKER] Problem results updated for user %s, problem %d (score = %.1f, penalty = %d)\n", s.Sub.UserID, s.Problem.ID, pr.Score, pr.Penalty)
 
-	return pr.Write(s.DB)
+	return pr.Write(ctx, s.DB)
 }
 
 // Update the submission's verdict.
@@ -101,8 +150,8 @@ func UpdateVerdict(tests []*models.TestGroupWithTests, sub *models.Submission) {
 }
 
 // TestResults returns the submission's test results, mapped by the test's ID.
-func (s *ScoreContext) TestResults() (map[int]*models.TestResult, error) {
-	trs, err := models.GetSubmissionTestResults(s.DB, s.Sub.ID)
+func (s *ScoreContext) TestResults(ctx context.Context) (map[int]*models.TestResult, error) {
+	trs, err := models.GetSubmissionTestResults(ctx, s.DB, s.Sub.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -114,12 +163,12 @@ func (s *ScoreContext) TestResults() (map[int]*models.TestResult, error) {
 }
 
 // ComputePenalties compute penalty values for each submission, based on the PenaltyPolicy.
-func (s *ScoreContext) ComputePenalties(sub *models.Submission) error {
+func (s *ScoreContext) ComputePenalties(ctx context.Context, sub *models.Submission) error {
 	value := 0
 	switch s.Problem.PenaltyPolicy {
 	case models.PenaltyPolicyNone:
 	case models.PenaltyPolicyICPC:
-		subs, err := models.GetUserProblemSubmissions(s.DB, s.Sub.UserID, s.Problem.ID)
+		subs, err := models.GetUserProblemSubmissions(ctx, s.DB, s.Sub.UserID, s.Problem.ID)
 		if err != nil {
 			return err
 		}
@@ -150,7 +199,14 @@ func scoreOf(sub *models.Submission) (float64, int, bool) {
 
 // CompareScores compare the submission results and return the best one.
 // If nil is returned, then the problem result should just be removed.
-func (s *ScoreContext) CompareScores(subs []*models.Submission) *models.ProblemResult {
+//
+// ctx is accepted for symmetry with the other ScoreContext methods; the
+// comparison itself is pure and never reads it.
+func (s *ScoreContext) CompareScores(ctx context.Context, subs []*models.Submission) *models.ProblemResult {
+	if s.Problem.ScoringMode == models.ScoringModeCodeforces {
+		return s.compareScoresCodeforces(subs)
+	}
+
 	maxScore := 0.0
 	var which *models.Submission
 	contestTime := float64(s.Contest.EndTime.Sub(s.Contest.StartTime))
@@ -171,7 +227,10 @@ func (s *ScoreContext) CompareScores(subs []*models.Submission) *models.ProblemR
 			which = sub
 			maxScore = score
 		case models.ScoringModeDecay:
-			score = math.Min(0.3,
+			// Floor the decayed ratio at 0.3, so even a late or
+			// much-retried submission scores at least 0.3 (math.Min here
+			// would cap every submission at 0.3 from the very first one).
+			score = math.Max(0.3,
 				(1.0-0.7*float64(sub.SubmittedAt.Sub(s.Contest.StartTime))/contestTime)*
 					(1.0-0.1*float64(counted)))
 			fallthrough
@@ -208,6 +267,58 @@ func (s *ScoreContext) CompareScores(subs []*models.Submission) *models.ProblemR
 	}
 }
 
+// compareScoresCodeforces implements models.ScoringModeCodeforces: the
+// problem's own maximum score decays linearly from MaxPoints to MinPoints
+// over the contest, and every wrong submission before the accepted one
+// costs a fixed WrongSubmissionPenalty. Unlike the other modes this looks
+// at a single submission (the first Accepted one) rather than picking the
+// best score across all of them — Codeforces never lets you resubmit for
+// more points once you've solved a problem.
+func (s *ScoreContext) compareScoresCodeforces(subs []*models.Submission) *models.ProblemResult {
+	p := s.Problem
+	var accepted *models.Submission
+	wrongCount := 0
+	for _, sub := range subs {
+		if _, _, counts := scoreOf(sub); !counts {
+			continue
+		}
+		if sub.Verdict == VerdictAccepted {
+			accepted = sub
+			break
+		}
+		wrongCount++
+	}
+
+	if accepted == nil {
+		return &models.ProblemResult{
+			BestSubmissionID: sql.NullInt64{},
+			Penalty:          0,
+			Score:            0.0,
+			Solved:           false,
+			ProblemID:        p.ID,
+			UserID:           s.Sub.UserID,
+		}
+	}
+
+	duration := float64(s.Contest.EndTime.Sub(s.Contest.StartTime))
+	elapsed := float64(accepted.SubmittedAt.Sub(s.Contest.StartTime))
+	decayRate := (p.MaxPoints - p.MinPoints) / duration
+	score := p.MaxPoints - decayRate*elapsed - p.WrongSubmissionPenalty*float64(wrongCount)
+	if score < p.MinPoints {
+		score = p.MinPoints
+	}
+
+	_, penalty, _ := scoreOf(accepted)
+	return &models.ProblemResult{
+		BestSubmissionID: sql.NullInt64{Int64: int64(accepted.ID), Valid: true},
+		Penalty:          penalty,
+		Score:            score,
+		Solved:           true,
+		ProblemID:        p.ID,
+		UserID:           s.Sub.UserID,
+	}
+}
+
 // ScoreGroup returns the score for a group.
 // If it returns false, the group's result should be hidden.
 func ScoreGroup(tg *models.TestGroupWithTests, results map[int]*models.TestResult) (float64, bool) {
@@ -231,6 +342,17 @@ func ScoreGroup(tg *models.TestGroupWithTests, results map[int]*models.TestResul
 			}
 		}
 		return tg.Score * ratio, true
+	case models.TestScoringModeMinRatio:
+		// IOI subtask semantics: the group is all-or-nothing. Any test
+		// scoring below full marks zeroes the whole group, rather than
+		// contributing a partial ratio.
+		for _, test := range tg.Tests {
+			result := results[test.ID]
+			if result.Score < 1.0 {
+				return 0, true
+			}
+		}
+		return tg.Score, true
 	case models.TestScoringModeProduct:
 		ratio := 1.0
 		for _, test := range tg.Tests {
@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"git.nkagami.me/natsukagami/kjudge/models"
+)
+
+func cfProblem() *models.Problem {
+	return &models.Problem{
+		ID:                     1,
+		ScoringMode:            models.ScoringModeCodeforces,
+		MaxPoints:              100,
+		MinPoints:              30,
+		WrongSubmissionPenalty: 10,
+	}
+}
+
+func cfContest(start, end time.Time) *models.Contest {
+	return &models.Contest{StartTime: start, EndTime: end}
+}
+
+func cfSub(id int, verdict string, submittedAt time.Time) *models.Submission {
+	return &models.Submission{
+		ID:             id,
+		UserID:         "alice",
+		ProblemID:      1,
+		CompiledSource: []byte("ok"),
+		Score:          sql.NullFloat64{Float64: 0, Valid: true},
+		Penalty:        sql.NullInt64{Int64: 0, Valid: true},
+		Verdict:        verdict,
+		SubmittedAt:    submittedAt,
+	}
+}
+
+// TestCompareScoresCodeforces_AcceptedFirstSubmission: solving on the very
+// first submission at the very start of the contest should score full
+// MaxPoints, with no decay and no wrong-submission penalty applied.
+func TestCompareScoresCodeforces_AcceptedFirstSubmission(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(5 * time.Hour)
+	contest := cfContest(start, end)
+	problem := cfProblem()
+
+	accepted := cfSub(1, VerdictAccepted, start)
+	s := &ScoreContext{Sub: accepted, Problem: problem, Contest: contest}
+
+	pr := s.compareScoresCodeforces([]*models.Submission{accepted})
+	if !pr.Solved {
+		t.Fatalf("expected Solved = true")
+	}
+	if pr.Score != problem.MaxPoints {
+		t.Fatalf("expected score %.1f, got %.1f", problem.MaxPoints, pr.Score)
+	}
+}
+
+// TestCompareScoresCodeforces_AllWrong: with no Accepted submission at all,
+// the problem result should record no solve and no score, regardless of
+// how many wrong submissions were made.
+func TestCompareScoresCodeforces_AllWrong(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(5 * time.Hour)
+	contest := cfContest(start, end)
+	problem := cfProblem()
+
+	subs := []*models.Submission{
+		cfSub(1, VerdictScored, start),
+		cfSub(2, VerdictScored, start.Add(time.Hour)),
+	}
+	s := &ScoreContext{Sub: subs[0], Problem: problem, Contest: contest}
+
+	pr := s.compareScoresCodeforces(subs)
+	if pr.Solved {
+		t.Fatalf("expected Solved = false")
+	}
+	if pr.Score != 0 {
+		t.Fatalf("expected score 0, got %.1f", pr.Score)
+	}
+	if pr.BestSubmissionID.Valid {
+		t.Fatalf("expected no best submission")
+	}
+}
+
+// TestCompareScoresCodeforces_AcceptedAtEndTime: accepting exactly at
+// EndTime (elapsed == duration) should floor at MinPoints, not dip below it.
+func TestCompareScoresCodeforces_AcceptedAtEndTime(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(5 * time.Hour)
+	contest := cfContest(start, end)
+	problem := cfProblem()
+
+	accepted := cfSub(1, VerdictAccepted, end)
+	s := &ScoreContext{Sub: accepted, Problem: problem, Contest: contest}
+
+	pr := s.compareScoresCodeforces([]*models.Submission{accepted})
+	if !pr.Solved {
+		t.Fatalf("expected Solved = true")
+	}
+	if pr.Score != problem.MinPoints {
+		t.Fatalf("expected score floored at %.1f, got %.1f", problem.MinPoints, pr.Score)
+	}
+}
+
+// TestCompareScoresCodeforces_WrongSubmissionPenalty checks that each wrong
+// submission before the accepted one subtracts WrongSubmissionPenalty.
+func TestCompareScoresCodeforces_WrongSubmissionPenalty(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Hour)
+	contest := cfContest(start, end)
+	problem := cfProblem()
+
+	subs := []*models.Submission{
+		cfSub(1, VerdictScored, start),
+		cfSub(2, VerdictScored, start),
+		cfSub(3, VerdictAccepted, start),
+	}
+	s := &ScoreContext{Sub: subs[0], Problem: problem, Contest: contest}
+
+	pr := s.compareScoresCodeforces(subs)
+	want := problem.MaxPoints - 2*problem.WrongSubmissionPenalty
+	if pr.Score != want {
+		t.Fatalf("expected score %.1f, got %.1f", want, pr.Score)
+	}
+}
+
+// TestCompareScores_DecayFloor ensures the decay ratio is floored at 0.3
+// (math.Max), rather than capped at 0.3 from the very first submission
+// (the math.Min bug this fixed).
+func TestCompareScores_DecayFloor(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Hour)
+	contest := cfContest(start, end)
+	problem := &models.Problem{ID: 1, ScoringMode: models.ScoringModeDecay}
+
+	sub := cfSub(1, VerdictAccepted, start)
+	s := &ScoreContext{Sub: sub, Problem: problem, Contest: contest}
+
+	pr := s.CompareScores(context.Background(), []*models.Submission{sub})
+	if pr.Score <= 0.3 {
+		t.Fatalf("expected an early, undecayed submission to score above the 0.3 floor, got %.2f", pr.Score)
+	}
+}
+
+// TestCompareScores_DecayFloor_Clamps drives the raw decay ratio below 0.3
+// (a submission at the very end of the contest, with one earlier counted
+// submission already pushing the per-submission decay further down) and
+// checks the result is clamped to exactly 0.3, not left below it.
+func TestCompareScores_DecayFloor_Clamps(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Hour)
+	contest := cfContest(start, end)
+	problem := &models.Problem{ID: 1, ScoringMode: models.ScoringModeDecay}
+
+	// Raw ratio for the second submission: (1 - 0.7*1) * (1 - 0.1*1) = 0.27,
+	// which must clamp to 0.3.
+	subs := []*models.Submission{
+		cfSub(1, VerdictScored, start),
+		cfSub(2, VerdictAccepted, end),
+	}
+	s := &ScoreContext{Sub: subs[1], Problem: problem, Contest: contest}
+
+	pr := s.CompareScores(context.Background(), subs)
+	if pr.Score != 0.3 {
+		t.Fatalf("expected the decayed ratio to clamp to exactly 0.3, got %.4f", pr.Score)
+	}
+}
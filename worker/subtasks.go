@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"github.com/pkg/errors"
+
+	"git.nkagami.me/natsukagami/kjudge/models"
+)
+
+// DependenciesSatisfied reports whether every group tg.Dependencies refers to
+// scored full marks, as recorded in scores (group ID -> score achieved).
+// A missing entry in scores is treated as "not yet scored", i.e. not
+// satisfied, so dependants default to being skipped rather than awarded.
+//
+// Callers must have scored every group in topological order (see
+// TopologicalOrder) before consulting this for a given tg, otherwise a
+// dependency that is merely scored *later* in iteration order looks
+// indistinguishable from one that failed.
+func DependenciesSatisfied(tg *models.TestGroupWithTests, groups map[int]*models.TestGroupWithTests, scores map[int]float64) bool {
+	return UnsatisfiedDependency(tg, groups, scores) == 0
+}
+
+// UnsatisfiedDependency returns the first dependency group ID of tg whose
+// full marks haven't been achieved in scores, or 0 if tg's dependencies are
+// all satisfied. It powers the submission page's "skipped: depends on
+// subtask N" badge.
+func UnsatisfiedDependency(tg *models.TestGroupWithTests, groups map[int]*models.TestGroupWithTests, scores map[int]float64) int {
+	for _, dep := range tg.Dependencies {
+		depGroup, ok := groups[dep]
+		if !ok {
+			continue
+		}
+		if scores[dep] != depGroup.Score {
+			return dep
+		}
+	}
+	return 0
+}
+
+// TopologicalOrder returns groups reordered so that every group comes after
+// every group it (transitively) depends on, via a DFS-based topological
+// sort. worker.Score relies on this to gate dependent groups correctly
+// regardless of what order models.GetProblemTests happened to return them
+// in.
+//
+// Returns an error naming the cycle the moment one is found.
+func TopologicalOrder(groups []*models.TestGroupWithTests) ([]*models.TestGroupWithTests, error) {
+	byID := make(map[int]*models.TestGroupWithTests, len(groups))
+	for _, tg := range groups {
+		byID[tg.ID] = tg
+	}
+
+	const (
+		unvisited = 0
+		visiting  = 1
+		done      = 2
+	)
+	state := make(map[int]int, len(groups))
+	order := make([]*models.TestGroupWithTests, 0, len(groups))
+
+	var visit func(id int, path []int) error
+	visit = func(id int, path []int) error {
+		switch state[id] {
+		case done:
+			return nil
+		case visiting:
+			return errors.Errorf("cycle detected in test group dependencies: %v -> %d", path, id)
+		}
+		state[id] = visiting
+		tg, ok := byID[id]
+		if !ok {
+			state[id] = done
+			return nil // Dangling dependency; nothing to order.
+		}
+		for _, dep := range tg.Dependencies {
+			if err := visit(dep, append(path, id)); err != nil {
+				return err
+			}
+		}
+		state[id] = done
+		order = append(order, tg)
+		return nil
+	}
+
+	for _, tg := range groups {
+		if err := visit(tg.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// DetectDependencyCycle reports whether groups' Dependencies form a valid
+// DAG, without caring about the resulting order. It is a thin wrapper
+// around TopologicalOrder for callers that only want the validation, not
+// the order itself — e.g. a problem-config loader that wants to reject a
+// cyclic subtask graph as soon as it's parsed, before it ever reaches
+// scoring. No such caller exists in this tree yet: today the cycle check
+// only happens lazily, the first time Score (or skippedTestGroups) calls
+// TopologicalOrder on a submission.
+func DetectDependencyCycle(groups []*models.TestGroupWithTests) error {
+	_, err := TopologicalOrder(groups)
+	return err
+}